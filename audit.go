@@ -0,0 +1,175 @@
+// Copyright 2026 GoFurry
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package corazalite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a forensic record of one transaction, written to an
+// AuditSink regardless of whether the request was blocked.
+// AuditEntry 是一次事务的取证记录, 无论请求是否被拦截都会写入 AuditSink
+type AuditEntry struct {
+	TxID      string    `json:"tx_id"`
+	Timestamp time.Time `json:"timestamp"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Blocked   bool      `json:"blocked"`
+	RuleIDs   []int     `json:"rule_ids,omitempty"`
+	Severity  string    `json:"severity,omitempty"`
+}
+
+// AuditSink receives an AuditEntry for every transaction processed by an
+// Engine. Implementations must be safe for concurrent use, since handle may
+// invoke Write from multiple requests at once.
+// AuditSink 接收 Engine 处理的每一次事务对应的 AuditEntry。实现必须保证并发
+// 安全, 因为 handle 可能同时为多个请求调用 Write
+type AuditSink interface {
+	Write(entry AuditEntry) error
+}
+
+// JSONLFileAuditSink writes one JSON object per line to a file, for
+// operators who want a local, grep-able audit trail.
+// JSONLFileAuditSink 将每条记录以 JSON 形式逐行写入文件, 适合希望在本地
+// 保留可直接 grep 的审计日志的运维场景
+type JSONLFileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewJSONLFileAuditSink opens (creating and appending to, if necessary) the
+// file at path for use as a JSONLFileAuditSink.
+// NewJSONLFileAuditSink 打开 path 对应的文件(不存在则创建, 已存在则追加),
+// 用作 JSONLFileAuditSink
+func NewJSONLFileAuditSink(path string) (*JSONLFileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log %s: %w", path, err)
+	}
+	return &JSONLFileAuditSink{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Write appends entry to the underlying file as a single JSON line.
+// Write 将 entry 作为单独一行 JSON 追加写入底层文件
+func (s *JSONLFileAuditSink) Write(entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(entry)
+}
+
+// Close closes the underlying file.
+// Close 关闭底层文件
+func (s *JSONLFileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// SyslogAuditSink forwards audit entries to a syslog daemon, as JSON
+// messages at Warning (blocked) or Info (allowed) severity.
+// SyslogAuditSink 将审计记录以 JSON 消息的形式转发给 syslog 守护进程, 被拦截
+// 的请求使用 Warning 级别, 其余使用 Info 级别
+type SyslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogAuditSink dials the syslog daemon at network/raddr (network == ""
+// dials the local daemon) and returns a SyslogAuditSink writing under tag.
+// NewSyslogAuditSink 拨号连接 network/raddr 指定的 syslog 守护进程
+// (network 为空时连接本机守护进程), 返回以 tag 标识写入的 SyslogAuditSink
+func NewSyslogAuditSink(network, raddr string, priority syslog.Priority, tag string) (*SyslogAuditSink, error) {
+	w, err := syslog.Dial(network, raddr, priority, tag)
+	if err != nil {
+		return nil, fmt.Errorf("dialing syslog: %w", err)
+	}
+	return &SyslogAuditSink{writer: w}, nil
+}
+
+// Write marshals entry to JSON and emits it at a severity matching
+// entry.Blocked.
+// Write 将 entry 序列化为 JSON, 并依据 entry.Blocked 选择对应的严重级别发出
+func (s *SyslogAuditSink) Write(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling audit entry: %w", err)
+	}
+	if entry.Blocked {
+		return s.writer.Warning(string(data))
+	}
+	return s.writer.Info(string(data))
+}
+
+// Close closes the underlying syslog connection.
+// Close 关闭底层 syslog 连接
+func (s *SyslogAuditSink) Close() error {
+	return s.writer.Close()
+}
+
+// KafkaMessage is a minimal key/value pair, decoupled from any concrete
+// Kafka client library so that using KafkaAuditSink doesn't force a new hard
+// dependency onto callers who don't need it.
+// KafkaMessage 是一个最小化的键值对, 与具体的 Kafka 客户端库解耦, 使得使用
+// KafkaAuditSink 不会给不需要它的调用方引入额外的硬依赖
+type KafkaMessage struct {
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer is the subset of a Kafka client's behavior KafkaAuditSink
+// relies on. Adapt any concrete client (segmentio/kafka-go,
+// confluent-kafka-go, ...) to this interface to use it as an AuditSink.
+// KafkaProducer 是 KafkaAuditSink 所依赖的 Kafka 客户端行为子集。将任意
+// 具体客户端(segmentio/kafka-go、confluent-kafka-go 等)适配到该接口即可
+// 用作 AuditSink
+type KafkaProducer interface {
+	WriteMessages(ctx context.Context, topic string, msgs ...KafkaMessage) error
+}
+
+// KafkaAuditSink publishes audit entries as JSON-encoded Kafka messages,
+// keyed by transaction ID, for operators streaming audit data into a
+// broader pipeline.
+// KafkaAuditSink 将审计记录编码为 JSON 并以事务 ID 作为 key 发布到 Kafka,
+// 适合需要将审计数据接入更大数据管道的场景
+type KafkaAuditSink struct {
+	producer KafkaProducer
+	topic    string
+}
+
+// NewKafkaAuditSink returns a KafkaAuditSink publishing to topic via
+// producer.
+// NewKafkaAuditSink 返回一个通过 producer 向 topic 发布消息的 KafkaAuditSink
+func NewKafkaAuditSink(producer KafkaProducer, topic string) *KafkaAuditSink {
+	return &KafkaAuditSink{producer: producer, topic: topic}
+}
+
+// Write marshals entry to JSON and publishes it keyed by entry.TxID.
+// Write 将 entry 序列化为 JSON, 以 entry.TxID 为 key 发布
+func (s *KafkaAuditSink) Write(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling audit entry: %w", err)
+	}
+	return s.producer.WriteMessages(context.Background(), s.topic, KafkaMessage{
+		Key:   []byte(entry.TxID),
+		Value: data,
+	})
+}
@@ -14,6 +14,9 @@
 package corazalite
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -24,6 +27,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/corazawaf/coraza/v3"
 	"github.com/corazawaf/coraza/v3/debuglog"
@@ -31,18 +36,24 @@ import (
 	"github.com/corazawaf/coraza/v3/types"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // ==========================================================
 // Global variables & initialization 全局变量与初始化
 // ==========================================================
 
-// globalWAF is the singleton instance of Coraza WAF
-// Coraza WAF 的全局单例实例
+// defaultEngine backs the package-level global WAF API (InitGlobalWAF*,
+// CorazaMiddleware). It is a regular *Engine like any returned by New, just
+// published through a sync.Once for callers that don't need per-tenant
+// engines.
+// defaultEngine 是全局 WAF API (InitGlobalWAF*、CorazaMiddleware) 背后
+// 使用的实例, 本质上与 New 返回的 *Engine 没有区别, 只是通过 sync.Once
+// 发布, 用于兼容不需要多租户引擎的调用方
 var (
-	globalWAF  coraza.WAF
-	wafOnce    sync.Once
-	wafInitErr error
+	defaultEngine *Engine
+	wafOnce       sync.Once
+	wafInitErr    error
 )
 
 // wafBlockMessage defines the response message returned when a request is blocked
@@ -69,6 +80,22 @@ type CorazaCfg struct {
 	RuleEngine     string   // Rule engine mode / 规则引擎模式
 	RootFS         fs.FS    // Root filesystem / 规则文件使用的根文件系统
 
+	// Additional rule sources, wired in alongside DirectivesFile. Directives
+	// is for inline SecLang strings; EmbeddedFS/EmbeddedPaths reads rule
+	// files out of a Go fs.FS (e.g. a //go:embed'd conf/coreruleset
+	// directory) so a containerized/embedded deployment ships the CRS
+	// inside the binary without touching the filesystem; RemoteDirectives
+	// fetches rule files over HTTP(S), optionally checksum-verified.
+	// 额外的规则来源, 与 DirectivesFile 一并生效。Directives 用于内联的
+	// SecLang 字符串; EmbeddedFS/EmbeddedPaths 从 Go 的 fs.FS 中读取规则
+	// 文件(例如通过 //go:embed 打包的 conf/coreruleset 目录), 使容器化/
+	// 内嵌部署无需依赖文件系统即可将 CRS 打进二进制; RemoteDirectives 通过
+	// HTTP(S) 拉取规则文件, 可选校验和校验
+	Directives       []string
+	EmbeddedFS       fs.FS
+	EmbeddedPaths    []string
+	RemoteDirectives []RemoteDirective
+
 	// Request body configuration
 	// 请求体配置
 	RequestBodyAccess        bool
@@ -85,6 +112,86 @@ type CorazaCfg struct {
 	// 日志配置
 	DebugLogger    debuglog.Logger
 	EnableErrorLog bool
+
+	// OnMatch, when set, is fired for every MatchedRule in addition to
+	// logError (when EnableErrorLog is set), regardless of whether the
+	// match produced an interruption. It lets the out-of-band engine below
+	// report hits on its own channel, or any engine ship matches to a SIEM.
+	// OnMatch 在每次规则命中时触发(无论是否产生拦截), 与 logError 同时生效
+	// (当 EnableErrorLog 为 true 时); 带外引擎可借此使用独立的上报通道,
+	// 任意引擎也都能借此将命中结果上报给 SIEM 等系统
+	OnMatch func(types.MatchedRule)
+
+	// OnInterrupt, when set, renders the response for a blocked request or
+	// response instead of the built-in JSON block page. It receives the
+	// interruption and the transaction it came from, so handlers can read
+	// matched-rule metadata via tx.MatchedRules()/tx.ID(). See
+	// OnInterruptJSON/OnInterruptHTML/OnInterruptText/OnInterruptRedirect
+	// for ready-made handlers.
+	// OnInterrupt 用于替换默认的 JSON 拦截响应, 可获取拦截结果与其所属事务,
+	// 从而通过 tx.MatchedRules()/tx.ID() 读取命中规则的元数据。内置实现见
+	// OnInterruptJSON/OnInterruptHTML/OnInterruptText/OnInterruptRedirect
+	OnInterrupt func(c *fiber.Ctx, it *types.Interruption, tx types.Transaction) error
+
+	// Out-of-band (detection-only) configuration
+	// 带外(仅检测)配置
+	//
+	// OutOfBandDirectivesFile is a shorthand that builds a default
+	// out-of-band sub-config from a list of directive files.
+	// OutOfBandCorazaCfg, when set, is used verbatim instead and takes
+	// precedence. Modeled after the CrowdSec Coraza integration: the
+	// out-of-band engine only observes traffic via OnMatch/logError and
+	// never influences the response.
+	// OutOfBandDirectivesFile 是仅需规则文件路径时的简化写法, 会据此构造
+	// 默认的带外子配置; 若设置了 OutOfBandCorazaCfg 则优先使用后者。
+	// 参考 CrowdSec 的 Coraza 集成方式: 带外引擎只通过 OnMatch/logError
+	// 观察流量, 不影响响应结果
+	OutOfBandDirectivesFile []string
+	OutOfBandCorazaCfg      *CorazaCfg
+
+	// Observability configuration
+	// 可观测性配置
+	//
+	// MetricsRegisterer, when set, registers waf_requests_total,
+	// waf_blocked_total, waf_processing_seconds and waf_body_bytes on it.
+	// AuditSink, when set, receives an AuditEntry for every transaction,
+	// for real-time shipping or forensic replay of blocked requests -
+	// complementing logError/OnMatch, which only ever see one rule at a
+	// time, with a record of each transaction as a whole.
+	// MetricsRegisterer 设置后会在其上注册 waf_requests_total、
+	// waf_blocked_total、waf_processing_seconds 与 waf_body_bytes。
+	// AuditSink 设置后会在每个事务结束时收到一条 AuditEntry, 用于实时上报
+	// 或对被拦截请求做取证回放 —— 与只能看到单条规则的 logError/OnMatch
+	// 互补, 提供一份完整事务维度的记录
+	MetricsRegisterer prometheus.Registerer
+	AuditSink         AuditSink
+}
+
+// defaultRemoteDirectiveTimeout bounds fetchRemoteDirective when
+// RemoteDirective.Timeout is left unset, so a slow or unresponsive remote
+// source can't hang the goroutine calling Reload (including WatchDirectives'
+// background loop) forever.
+// defaultRemoteDirectiveTimeout 在 RemoteDirective.Timeout 未设置时用于限制
+// fetchRemoteDirective 的耗时, 避免一个响应缓慢或无响应的远程源永久阻塞
+// 调用 Reload 的 goroutine(包括 WatchDirectives 的后台循环)
+const defaultRemoteDirectiveTimeout = 10 * time.Second
+
+// RemoteDirective describes a SecLang directive file fetched over HTTP(S),
+// e.g. a CRS bundle hosted by a rule-delivery service. SHA256, when set,
+// must match the hex-encoded checksum of the fetched body or the WAF fails
+// to build; RefreshInterval is consulted by WatchDirectives to decide how
+// often this source should be re-fetched; Timeout bounds the HTTP fetch
+// itself and defaults to defaultRemoteDirectiveTimeout when zero.
+// RemoteDirective 描述一个通过 HTTP(S) 拉取的 SecLang 规则文件, 例如由规则
+// 分发服务托管的 CRS 包。SHA256 非空时必须与拉取内容的十六进制校验和一致,
+// 否则 WAF 构建失败; RefreshInterval 供 WatchDirectives 判断该来源的
+// 重新拉取周期; Timeout 限制本次 HTTP 拉取的耗时, 为零值时回退到
+// defaultRemoteDirectiveTimeout
+type RemoteDirective struct {
+	URL             string
+	SHA256          string
+	RefreshInterval time.Duration
+	Timeout         time.Duration
 }
 
 // DefaultCorazaCfg returns default configuration | 返回默认配置
@@ -112,13 +219,45 @@ func DefaultCorazaCfg() CorazaCfg {
 // InitGlobalWAFWithCfg initializes the global WAF using config | 使用配置初始化全局 WAF
 func InitGlobalWAFWithCfg(cfg CorazaCfg) {
 	wafOnce.Do(func() {
-		globalWAF, wafInitErr = createWAFWithCfg(cfg)
-		if wafInitErr != nil {
-			slog.Error("[CorazaWAF] initialization failed", wafInitErr.Error())
+		e, err := New(cfg)
+		if err != nil {
+			wafInitErr = err
+			slog.Error("[CorazaWAF] initialization failed", err.Error())
+			return
 		}
+		defaultEngine = e
 	})
 }
 
+// InitGlobalWAFInBandOutOfBand initializes an in-band engine that blocks
+// matching requests and an out-of-band engine that only logs, running them
+// concurrently. It is sugar for InitGlobalWAFWithCfg(inband) with oob
+// attached as inband.OutOfBandCorazaCfg.
+// InitGlobalWAFInBandOutOfBand 同时初始化一个用于拦截的带内引擎与一个仅记录
+// 日志的带外引擎并发运行, 等价于将 oob 作为 inband.OutOfBandCorazaCfg 后调用
+// InitGlobalWAFWithCfg(inband)
+func InitGlobalWAFInBandOutOfBand(inband, oob CorazaCfg) {
+	inband.OutOfBandCorazaCfg = &oob
+	InitGlobalWAFWithCfg(inband)
+}
+
+// outOfBandCfg resolves the effective out-of-band sub-config from cfg, if
+// any was configured. OutOfBandCorazaCfg takes precedence over the
+// OutOfBandDirectivesFile shorthand.
+// outOfBandCfg 解析出 cfg 中实际生效的带外子配置(如果配置了的话),
+// OutOfBandCorazaCfg 优先于 OutOfBandDirectivesFile 简化写法
+func outOfBandCfg(cfg CorazaCfg) (CorazaCfg, bool) {
+	if cfg.OutOfBandCorazaCfg != nil {
+		return *cfg.OutOfBandCorazaCfg, true
+	}
+	if len(cfg.OutOfBandDirectivesFile) > 0 {
+		oob := DefaultCorazaCfg()
+		oob.DirectivesFile = cfg.OutOfBandDirectivesFile
+		return oob, true
+	}
+	return CorazaCfg{}, false
+}
+
 // InitGlobalWAF initializes WAF with directive file path or default config | 使用规则文件路径或默认配置初始化 WAF
 func InitGlobalWAF(path ...string) {
 	if len(path) > 0 {
@@ -129,92 +268,558 @@ func InitGlobalWAF(path ...string) {
 }
 
 // ==========================================================
-// Fiber Middleware 中间件实现
+// Multi-tenant engines 多租户引擎
 // ==========================================================
 
-// CorazaMiddleware returns a Fiber handler with Coraza WAF enabled | 返回启用 Coraza WAF 的 Fiber 中间件
-func CorazaMiddleware() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		if wafInitErr != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"code": 0,
-				"msg":  "WAF initialization failed",
-			})
+// Engine is a self-contained Coraza WAF instance with its own configuration,
+// in-band rule engine, and optional out-of-band rule engine. Unlike the
+// package-level global WAF, multiple Engines can coexist, so different
+// hosts, route groups, or tenants can enforce different rule sets.
+// Engine 是一个自包含的 Coraza WAF 实例, 拥有独立的配置、带内规则引擎以及
+// 可选的带外规则引擎。与包级别的全局 WAF 不同, 多个 Engine 可以共存,
+// 从而让不同 host、路由分组或租户使用各自的规则集
+// Engine holds its WAF(s) behind atomic.Pointer so Reload can swap them in
+// without a lock on the request hot path: in-flight transactions keep using
+// the coraza.WAF they were created from, new transactions pick up whatever
+// waf.Load() currently points at.
+// Engine 将其 WAF 实例放在 atomic.Pointer 之后, 使 Reload 可以在不加锁的
+// 情况下于请求热路径上完成替换: 正在处理中的事务继续使用创建时的
+// coraza.WAF, 新事务则使用 waf.Load() 当前指向的实例
+type Engine struct {
+	cfg atomic.Pointer[CorazaCfg]
+	waf atomic.Pointer[coraza.WAF]
+
+	oobWAF     atomic.Pointer[coraza.WAF]
+	oobEnabled atomic.Bool
+
+	// metrics and audit are fixed at construction time rather than atomics
+	// like the fields above: metrics collectors are registered once with
+	// cfg.MetricsRegisterer and re-registering them on every Reload would
+	// panic, and the audit sink is a long-lived resource (e.g. an open
+	// file or syslog connection) that Reload has no reason to replace.
+	// metrics 与 audit 在构建时确定, 不像上面几个字段那样是原子值: 指标
+	// 采集器只会向 cfg.MetricsRegisterer 注册一次, 每次 Reload 都重新注册
+	// 会 panic; 审计 sink 则是长期持有的资源(如打开的文件或 syslog 连接),
+	// Reload 没有理由替换它
+	metrics *metrics
+	audit   AuditSink
+}
+
+// New builds a standalone Engine from cfg. It never panics: a missing
+// directives file or invalid WAF config is returned as an error, so
+// constructing one Engine per tenant is safe to do at route-registration
+// time.
+// New 根据 cfg 构建一个独立的 Engine。它不会 panic: 规则文件缺失或 WAF
+// 配置非法都以 error 形式返回, 因此可以安全地在
+// 路由注册阶段为每个租户构建各自的 Engine
+func New(cfg CorazaCfg) (*Engine, error) {
+	m, err := newMetrics(cfg.MetricsRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		metrics: m,
+		audit:   cfg.AuditSink,
+	}
+	if err := e.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload builds a new WAF (and out-of-band WAF, if cfg configures one) and
+// atomically swaps them in, so rule updates take effect without restarting
+// the process. Building happens before any swap, so a bad cfg leaves the
+// previously active WAF(s) untouched and returns an error instead.
+// Reload 构建新的 WAF(以及 cfg 中配置的带外 WAF)并原子替换当前实例,
+// 从而无需重启进程即可让规则更新生效。替换前会先完成构建, 因此非法的
+// cfg 不会影响当前生效的 WAF, 而是直接返回 error
+func (e *Engine) Reload(cfg CorazaCfg) error {
+	// Wrap OnMatch on a copy of cfg rather than cfg itself: cfg (and its
+	// stored OnMatch) is kept pristine below so a later Reload wraps the
+	// caller's original callback again instead of re-wrapping an
+	// already-wrapped one.
+	// 在 cfg 的副本上包装 OnMatch, 而不是直接修改 cfg 本身: 下面存储的 cfg
+	// (及其 OnMatch)保持原样, 这样后续 Reload 包装的始终是调用方最初提供的
+	// 回调, 而不会在已包装的回调外再包一层
+	wafCfg := cfg
+	userOnMatch := cfg.OnMatch
+	wafCfg.OnMatch = func(mr types.MatchedRule) {
+		e.metrics.observeMatch(mr)
+		if userOnMatch != nil {
+			userOnMatch(mr)
 		}
+	}
 
-		if globalWAF == nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"code": 0,
-				"msg":  "WAF instance not initialized",
-			})
+	waf, err := createWAFWithCfg(wafCfg)
+	if err != nil {
+		return err
+	}
+
+	var oobWAF coraza.WAF
+	oobEnabled := false
+	if oobCfg, ok := outOfBandCfg(cfg); ok {
+		oobWAF, err = createWAFWithCfg(oobCfg)
+		if err != nil {
+			return fmt.Errorf("out-of-band WAF: %w", err)
 		}
+		oobEnabled = true
+	}
+
+	e.cfg.Store(&cfg)
+	e.waf.Store(&waf)
+	e.oobWAF.Store(&oobWAF)
+	e.oobEnabled.Store(oobEnabled)
+	return nil
+}
 
-		newTX := func(*http.Request) types.Transaction {
-			return globalWAF.NewTransaction()
+// WatchDirectives starts a background goroutine that polls every interval
+// and calls Reload when a configured rule source changed: DirectivesFile
+// entries are detected via mtime, RemoteDirectives are re-fetched according
+// to their own RefreshInterval. It returns a stop function that terminates
+// the watch loop.
+// WatchDirectives 启动一个后台 goroutine, 每隔 interval 检查一次配置的
+// 规则来源是否发生变化: DirectivesFile 通过 mtime 检测, RemoteDirectives
+// 按各自的 RefreshInterval 重新拉取。返回的 stop 函数用于终止监听循环
+func (e *Engine) WatchDirectives(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		initial := e.cfg.Load()
+		mtimes := fileModTimes(initial.DirectivesFile)
+		lastRemoteFetch := map[string]time.Time{}
+		for _, rd := range initial.RemoteDirectives {
+			lastRemoteFetch[rd.URL] = time.Now()
 		}
 
-		if ctxwaf, ok := globalWAF.(experimental.WAFWithOptions); ok {
-			newTX = func(r *http.Request) types.Transaction {
-				return ctxwaf.NewTransactionWithOptions(experimental.Options{
-					Context: r.Context(),
-				})
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				cfg := *e.cfg.Load()
+
+				newMtimes := fileModTimes(cfg.DirectivesFile)
+				changed := false
+				for path, mt := range newMtimes {
+					if mtimes[path] != mt {
+						changed = true
+					}
+				}
+
+				for _, rd := range cfg.RemoteDirectives {
+					if rd.RefreshInterval <= 0 {
+						continue
+					}
+					if time.Since(lastRemoteFetch[rd.URL]) >= rd.RefreshInterval {
+						changed = true
+						lastRemoteFetch[rd.URL] = time.Now()
+					}
+				}
+
+				if !changed {
+					continue
+				}
+
+				if err := e.Reload(cfg); err != nil {
+					slog.Error(fmt.Sprintf("WAF reload failed: %v", err))
+					continue
+				}
+				mtimes = newMtimes
 			}
 		}
+	}()
 
-		stdReq, err := convertFasthttpToStdRequest(c)
-		if err != nil {
+	return func() { close(done) }
+}
+
+// fileModTimes stats paths, skipping any that can't be stat'd, for use by
+// WatchDirectives' change detection.
+// fileModTimes 获取 paths 的修改时间, 无法 stat 的路径会被跳过, 供
+// WatchDirectives 的变更检测使用
+func fileModTimes(paths []string) map[string]time.Time {
+	mtimes := make(map[string]time.Time, len(paths))
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			mtimes[p] = info.ModTime()
+		}
+	}
+	return mtimes
+}
+
+// ReloadHandler returns a Fiber handler that reloads e from cfg() on every
+// request, for wiring up an admin endpoint such as:
+//
+//	admin.Post("/waf/reload", authMiddleware, corazalite.ReloadHandler(engine, loadCfg))
+//
+// cfg is invoked fresh on every call (e.g. re-reading a directives file path
+// or fetching a config service) so the caller controls what "reload" means.
+// Guarding the route with auth is the caller's responsibility, not this
+// handler's.
+// ReloadHandler 返回一个 Fiber 处理函数, 每次请求都会用 cfg() 重新加载 e,
+// 便于接入管理端点。cfg 每次调用都会重新求值(例如重新读取规则文件路径或
+// 请求配置服务), 由调用方决定"重新加载"的含义; 是否需要鉴权同样由调用方
+// 负责, 与本处理函数无关
+func ReloadHandler(e *Engine, cfg func() CorazaCfg) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := e.Reload(cfg()); err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"code": 0,
-				"msg":  "Failed to convert request",
+				"msg":  fmt.Sprintf("WAF reload failed: %v", err),
 			})
 		}
+		return c.JSON(fiber.Map{
+			"code": 1,
+			"msg":  "WAF reloaded",
+		})
+	}
+}
 
-		tx := newTX(stdReq)
-		defer func() {
-			if r := recover(); r != nil {
-				slog.Error(fmt.Sprintf("WAF panic: %v", r))
-			}
-			tx.ProcessLogging()
-			_ = tx.Close()
-		}()
+// Middleware returns a Fiber handler enforcing e's rules, independent of the
+// package-level global WAF and any other Engine.
+// Middleware 返回一个执行 e 规则集的 Fiber 中间件, 与包级别的全局 WAF
+// 以及其他 Engine 相互独立
+func (e *Engine) Middleware() fiber.Handler {
+	return e.handle
+}
 
-		if tx.IsRuleEngineOff() {
+// HostRouter dispatches requests to different engines based on c.Hostname(),
+// for multi-tenant deployments where each host enforces its own rule set.
+// Hosts absent from routes fall back to fallback; a nil fallback means the
+// request continues without WAF enforcement.
+// HostRouter 依据 c.Hostname() 将请求分发给不同的 Engine, 适用于不同 host
+// 需要各自规则集的多租户部署。未命中 routes 的 host 会回退到 fallback,
+// fallback 为 nil 表示直接放行, 不经过 WAF
+func HostRouter(routes map[string]*Engine, fallback *Engine) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		engine, ok := routes[c.Hostname()]
+		if !ok {
+			engine = fallback
+		}
+		if engine == nil {
 			return c.Next()
 		}
+		return engine.handle(c)
+	}
+}
 
-		if it, err := processRequest(tx, stdReq); err != nil {
+// ==========================================================
+// Fiber Middleware 中间件实现
+// ==========================================================
+
+// CorazaMiddleware returns a Fiber handler backed by the package-level
+// global WAF initialized via InitGlobalWAF/InitGlobalWAFWithCfg. For
+// multi-tenant setups prefer New(cfg).Middleware() or HostRouter.
+// CorazaMiddleware 返回由 InitGlobalWAF/InitGlobalWAFWithCfg 初始化的全局 WAF
+// 所驱动的 Fiber 中间件。多租户场景建议使用 New(cfg).Middleware() 或 HostRouter
+func CorazaMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if wafInitErr != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"code": 0,
-				"msg":  "WAF request processing failed",
+				"msg":  "WAF initialization failed",
 			})
-		} else if it != nil {
-			status := obtainStatusCodeFromInterruptionOrDefault(it, http.StatusForbidden)
-			c.Set("X-WAF-Blocked", "true")
-			return c.Status(status).JSON(fiber.Map{
+		}
+
+		if defaultEngine == nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"code": 0,
-				"msg":  wafBlockMessage,
+				"msg":  "WAF instance not initialized",
 			})
 		}
 
+		return defaultEngine.handle(c)
+	}
+}
+
+// handle is the shared Fiber handler body for e, used by both Middleware()
+// and HostRouter.
+// handle 是 e 对应的 Fiber 处理逻辑, Middleware() 与 HostRouter 共用
+func (e *Engine) handle(c *fiber.Ctx) error {
+	start := time.Now()
+	e.metrics.observeRequest()
+
+	// Time spent in downstream handlers is excluded from waf_processing_seconds.
+	var nextElapsed time.Duration
+	next := func() error {
+		nextStart := time.Now()
+		defer func() { nextElapsed += time.Since(nextStart) }()
 		return c.Next()
 	}
+
+	stdReq, err := convertFasthttpToStdRequest(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"code": 0,
+			"msg":  "Failed to convert request",
+		})
+	}
+
+	tx := newTransaction(*e.waf.Load(), stdReq)
+	blocked := false
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error(fmt.Sprintf("WAF panic: %v", r))
+		}
+		tx.ProcessLogging()
+		e.writeAuditEntry(tx, stdReq, blocked)
+		e.metrics.observeProcessing(time.Since(start) - nextElapsed)
+		_ = tx.Close()
+	}()
+
+	if e.oobEnabled.Load() {
+		if oobReq, err := convertFasthttpToStdRequest(c); err == nil {
+			if oobReq, err = cloneRequestForBackground(oobReq); err == nil {
+				go e.dispatchOutOfBand(oobReq)
+			}
+		}
+	}
+
+	if tx.IsRuleEngineOff() {
+		return next()
+	}
+
+	it, n, err := processRequest(tx, stdReq)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"code": 0,
+			"msg":  "WAF request processing failed",
+		})
+	}
+	e.metrics.observeBodyBytes(n)
+	if it != nil {
+		blocked = true
+		return e.writeBlockResponse(c, tx, it)
+	}
+
+	if err := next(); err != nil {
+		return err
+	}
+
+	cfg := *e.cfg.Load()
+	if cfg.ResponseBodyAccess {
+		it, n, err := processResponse(cfg, tx, c)
+		if err != nil {
+			slog.Error(fmt.Sprintf("WAF response processing failed: %v", err))
+			return nil
+		}
+		if it != nil {
+			blocked = true
+			return e.writeBlockResponse(c, tx, it)
+		}
+		e.metrics.observeBodyBytes(n)
+	}
+
+	return nil
 }
 
 // ==========================================================
 // Internal helpers 内部辅助方法
 // ==========================================================
 
+// writeBlockResponse renders the response for a blocked request/response,
+// shared by both the request and response inspection paths. It defers to
+// e.cfg.OnInterrupt when set, falling back to the built-in JSON/redirect
+// handling otherwise.
+// 渲染被拦截请求/响应对应的响应内容, 请求阶段与响应阶段共用。若配置了
+// e.cfg.OnInterrupt 则交由其处理, 否则回退到内置的 JSON/重定向逻辑
+func (e *Engine) writeBlockResponse(c *fiber.Ctx, tx types.Transaction, it *types.Interruption) error {
+	if onInterrupt := e.cfg.Load().OnInterrupt; onInterrupt != nil {
+		return onInterrupt(c, it, tx)
+	}
+	return defaultOnInterrupt(c, it, tx)
+}
+
+// defaultOnInterrupt is the built-in OnInterrupt behavior: "redirect"
+// interruptions carrying a location in it.Data are honored as a real HTTP
+// redirect, everything else falls back to OnInterruptJSON.
+// defaultOnInterrupt 是内置的 OnInterrupt 行为: 若拦截动作为 "redirect" 且
+// it.Data 中带有目标地址, 则执行真正的 HTTP 重定向, 其余情况回退到
+// OnInterruptJSON
+func defaultOnInterrupt(c *fiber.Ctx, it *types.Interruption, tx types.Transaction) error {
+	if it.Action == "redirect" && it.Data != "" {
+		c.Set("X-WAF-Blocked", "true")
+		return c.Redirect(it.Data, obtainStatusCodeFromInterruptionOrDefault(it, http.StatusFound))
+	}
+	return OnInterruptJSON(c, it, tx)
+}
+
+// writeAuditEntry builds an AuditEntry from tx and req and forwards it to
+// e.audit, if one is configured. It is called unconditionally from handle's
+// deferred cleanup, after tx.ProcessLogging, so the sink sees every
+// transaction rather than only blocked ones.
+// writeAuditEntry 根据 tx 与 req 构建 AuditEntry 并转发给 e.audit(若已配置)。
+// 在 handle 的延迟清理中、tx.ProcessLogging 之后无条件调用, 因此 sink 会收到
+// 每一次事务, 而不仅仅是被拦截的那些
+func (e *Engine) writeAuditEntry(tx types.Transaction, req *http.Request, blocked bool) {
+	if e.audit == nil {
+		return
+	}
+
+	ruleIDs, severity, txID := matchedRuleSummary(tx)
+	clientIP := req.RemoteAddr
+	if idx := strings.LastIndexByte(clientIP, ':'); idx != -1 {
+		clientIP = clientIP[:idx]
+	}
+	entry := AuditEntry{
+		TxID:      txID,
+		Timestamp: time.Now(),
+		ClientIP:  clientIP,
+		Method:    req.Method,
+		URI:       req.URL.String(),
+		Blocked:   blocked,
+		RuleIDs:   ruleIDs,
+		Severity:  severity,
+	}
+	if err := e.audit.Write(entry); err != nil {
+		slog.Error(fmt.Sprintf("audit sink write failed: %v", err))
+	}
+}
+
+// matchedRuleSummary collects the matched-rule metadata (rule IDs, the
+// highest severity seen, and the transaction ID) used by OnInterruptJSON
+// and writeAuditEntry, to let callers render templated block pages or feed
+// fail2ban/CrowdSec-style banning.
+// matchedRuleSummary 收集命中规则的元数据(规则 ID、出现过的最高严重级别、
+// 事务 ID), 供 OnInterruptJSON 与 writeAuditEntry 使用, 便于渲染模板化
+// 拦截页面或对接 fail2ban/CrowdSec 式封禁
+func matchedRuleSummary(tx types.Transaction) (ruleIDs []int, severity string, txID string) {
+	txID = tx.ID()
+	rules := tx.MatchedRules()
+	var highest types.RuleSeverity
+	haveSeverity := false
+	for _, mr := range rules {
+		ruleIDs = append(ruleIDs, mr.Rule().ID())
+		if s := mr.Rule().Severity(); !haveSeverity || s < highest {
+			highest = s
+			haveSeverity = true
+		}
+	}
+	if haveSeverity {
+		severity = highest.String()
+	}
+	return
+}
+
+// OnInterruptJSON is the classic built-in OnInterrupt handler, rendering
+// {"code":0,"msg":...} plus matched-rule metadata.
+// OnInterruptJSON 是经典的内置 OnInterrupt 实现, 渲染 {"code":0,"msg":...}
+// 并附带命中规则的元数据
+func OnInterruptJSON(c *fiber.Ctx, it *types.Interruption, tx types.Transaction) error {
+	status := obtainStatusCodeFromInterruptionOrDefault(it, http.StatusForbidden)
+	ruleIDs, severity, txID := matchedRuleSummary(tx)
+	c.Set("X-WAF-Blocked", "true")
+	return c.Status(status).JSON(fiber.Map{
+		"code":     0,
+		"msg":      wafBlockMessage,
+		"tx_id":    txID,
+		"rule_ids": ruleIDs,
+		"severity": severity,
+	})
+}
+
+// OnInterruptHTML is a built-in OnInterrupt handler that renders a minimal
+// HTML block page, for sites that would rather not show JSON to browsers.
+// OnInterruptHTML 是内置 OnInterrupt 实现, 渲染一个极简的 HTML 拦截页面,
+// 适合不希望向浏览器展示 JSON 的站点
+func OnInterruptHTML(c *fiber.Ctx, it *types.Interruption, tx types.Transaction) error {
+	status := obtainStatusCodeFromInterruptionOrDefault(it, http.StatusForbidden)
+	c.Set("X-WAF-Blocked", "true")
+	c.Type("html")
+	return c.Status(status).SendString(fmt.Sprintf("<html><body><h1>%s</h1></body></html>", wafBlockMessage))
+}
+
+// OnInterruptText is a built-in OnInterrupt handler that renders the block
+// message as plain text.
+// OnInterruptText 是内置 OnInterrupt 实现, 以纯文本形式返回拦截提示信息
+func OnInterruptText(c *fiber.Ctx, it *types.Interruption, tx types.Transaction) error {
+	status := obtainStatusCodeFromInterruptionOrDefault(it, http.StatusForbidden)
+	c.Set("X-WAF-Blocked", "true")
+	c.Type("txt")
+	return c.Status(status).SendString(wafBlockMessage)
+}
+
+// OnInterruptRedirect returns a built-in OnInterrupt handler that redirects
+// every blocked request/response to location, e.g. a "you have been
+// blocked" landing page, instead of rendering a block page inline.
+// OnInterruptRedirect 返回一个内置 OnInterrupt 实现, 将所有被拦截的
+// 请求/响应重定向到 location(例如一个专门的"已被拦截"提示页), 而不是
+// 直接渲染拦截页面
+func OnInterruptRedirect(location string) func(c *fiber.Ctx, it *types.Interruption, tx types.Transaction) error {
+	return func(c *fiber.Ctx, it *types.Interruption, tx types.Transaction) error {
+		c.Set("X-WAF-Blocked", "true")
+		return c.Redirect(location, http.StatusFound)
+	}
+}
+
+// newTransaction starts a transaction on waf, using the request's context
+// when the engine supports experimental.WAFWithOptions.
+// newTransaction 在 waf 上创建事务, 若引擎支持 experimental.WAFWithOptions
+// 则绑定请求的 context
+func newTransaction(waf coraza.WAF, r *http.Request) types.Transaction {
+	if ctxwaf, ok := waf.(experimental.WAFWithOptions); ok {
+		return ctxwaf.NewTransactionWithOptions(experimental.Options{
+			Context: r.Context(),
+		})
+	}
+	return waf.NewTransaction()
+}
+
+// dispatchOutOfBand runs req through the out-of-band engine on its own
+// transaction. It never returns an interruption to the caller: matches are
+// only surfaced through the out-of-band config's OnMatch/logError callback,
+// so this can run fully detached from the request/response cycle. Callers
+// running it in a goroutine (as handle does) must pass a request already
+// cloned via cloneRequestForBackground, since req is read after this
+// goroutine may outlive the fasthttp handler that produced it.
+// dispatchOutOfBand 在独立事务上让 req 经过带外引擎处理, 不会向调用方返回
+// 任何拦截结果: 命中规则只通过带外配置的 OnMatch/logError 回调上报,
+// 因此可以完全脱离请求/响应生命周期运行。以 goroutine 方式调用本函数的
+// 调用方(如 handle)必须传入已经过 cloneRequestForBackground 克隆的请求,
+// 因为该 goroutine 可能在产生 req 的 fasthttp handler 返回之后才读取它
+func (e *Engine) dispatchOutOfBand(req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error(fmt.Sprintf("out-of-band WAF panic: %v", r))
+		}
+	}()
+
+	tx := newTransaction(*e.oobWAF.Load(), req)
+	defer func() {
+		tx.ProcessLogging()
+		_ = tx.Close()
+	}()
+
+	if tx.IsRuleEngineOff() {
+		return
+	}
+
+	if _, _, err := processRequest(tx, req); err != nil {
+		slog.Error(fmt.Sprintf("out-of-band WAF processing failed: %v", err))
+	}
+}
+
 // logError handles matched WAF rules | 处理 WAF 规则命中日志
 func logError(error types.MatchedRule) {
 	slog.Warn("WAF rule matched",
-		slog.String("severity", string(error.Rule().Severity())),
+		slog.String("severity", error.Rule().Severity().String()),
 		slog.String("error_log", error.ErrorLog()),
 		slog.Int("rule_id", error.Rule().ID()),
 	)
 }
 
-// processRequest processes request through Coraza transaction | 使用 Coraza 事务处理请求
-func processRequest(tx types.Transaction, req *http.Request) (*types.Interruption, error) {
+// processRequest processes request through Coraza transaction. The returned
+// int is the number of request body bytes actually fed into the
+// transaction, for metrics; it is 0 whenever the body was not read (e.g.
+// RequestBodyAccess disabled).
+// processRequest 使用 Coraza 事务处理请求。返回的 int 是实际送入事务的请求体
+// 字节数, 供指标使用; 若请求体未被读取(例如 RequestBodyAccess 未启用),
+// 则为 0
+func processRequest(tx types.Transaction, req *http.Request) (*types.Interruption, int, error) {
 	var client string
 	var cport int
 
@@ -242,31 +847,109 @@ func processRequest(tx types.Transaction, req *http.Request) (*types.Interruptio
 	}
 
 	if in := tx.ProcessRequestHeaders(); in != nil {
-		return in, nil
+		return in, 0, nil
 	}
 
+	var n int
 	if tx.IsRequestBodyAccessible() && req.Body != nil && req.Body != http.NoBody {
-		it, _, err := tx.ReadRequestBodyFrom(req.Body)
+		it, read, err := tx.ReadRequestBodyFrom(req.Body)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		if it != nil {
-			return it, nil
+			return it, read, nil
 		}
+		n = read
 		rbr, _ := tx.RequestBodyReader()
 		req.Body = io.NopCloser(io.MultiReader(rbr, req.Body))
 	}
 
-	return tx.ProcessRequestBody()
+	it, err := tx.ProcessRequestBody()
+	return it, n, err
 }
 
-// obtainStatusCodeFromInterruptionOrDefault determines HTTP status code | 根据拦截结果确定 HTTP 状态码
+// processResponse feeds the Fiber response headers/status/body into the
+// transaction after c.Next() has run, analogous to Coraza's upstream
+// rwInterceptor/Wrap for net/http. It honors the configured MIME-type
+// filter (via tx.IsResponseBodyProcessable) and ResponseBodyLimit, and
+// transparently drains/rewraps streamed (SendStream) bodies. The returned
+// int is the number of body bytes actually inspected, for metrics; it is
+// 0 whenever the body was not read (headers-only interruption, or the
+// body was skipped by the MIME-type/accessibility checks).
+// 在 c.Next() 之后将响应头/状态码/响应体送入事务处理, 对应 Coraza 官方
+// http 包中的 rwInterceptor/Wrap。会遵循配置的 MIME 类型过滤与
+// ResponseBodyLimit 截断, 并对 SendStream 产生的流式响应体做透明处理。
+// 返回的 int 是实际被检查的响应体字节数, 供指标使用; 若响应体未被读取
+// (仅处理了响应头就被拦截, 或被 MIME 类型/可访问性检查跳过), 则为 0
+func processResponse(cfg CorazaCfg, tx types.Transaction, c *fiber.Ctx) (*types.Interruption, int, error) {
+	resp := c.Response()
+
+	resp.Header.VisitAll(func(key, value []byte) {
+		tx.AddResponseHeader(string(key), string(value))
+	})
+
+	proto := string(c.Request().Header.Protocol())
+	if it := tx.ProcessResponseHeaders(resp.StatusCode(), proto); it != nil {
+		return it, 0, nil
+	}
+
+	if !tx.IsResponseBodyAccessible() || !tx.IsResponseBodyProcessable() {
+		return nil, 0, nil
+	}
+
+	limit := cfg.ResponseBodyLimit
+	if limit <= 0 {
+		limit = DefaultCorazaCfg().ResponseBodyLimit
+	}
+
+	var body []byte
+	if resp.IsBodyStream() {
+		stream := resp.BodyStream()
+		buf := new(bytes.Buffer)
+		if _, err := io.CopyN(buf, stream, int64(limit)); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		body = buf.Bytes()
+		resp.SetBodyStream(io.MultiReader(bytes.NewReader(body), stream), -1)
+	} else {
+		body = resp.Body()
+		if len(body) > limit {
+			body = body[:limit]
+		}
+	}
+
+	it, _, err := tx.WriteResponseBody(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if it != nil {
+		return it, len(body), nil
+	}
+
+	it, err = tx.ProcessResponseBody()
+	return it, len(body), err
+}
+
+// obtainStatusCodeFromInterruptionOrDefault determines the HTTP status code
+// for an interruption, honoring "deny"/"block" (explicit status or 403),
+// "redirect" (302 unless overridden) and "drop" (handled like "deny":
+// explicit status or 403, since fasthttp cannot simply close the connection
+// mid-handler) in addition to the default.
+// 根据拦截结果确定 HTTP 状态码: "deny"/"block" 返回显式状态码或 403,
+// "redirect" 默认返回 302(可被覆盖), "drop" 与 "deny" 相同, 返回显式状态码
+// 或 403(fasthttp 无法在 handler 中途直接断开连接), 其余情况返回默认值
 func obtainStatusCodeFromInterruptionOrDefault(it *types.Interruption, defaultStatusCode int) int {
-	if it.Action == "deny" {
+	switch it.Action {
+	case "deny", "block", "drop":
 		if it.Status != 0 {
 			return it.Status
 		}
 		return http.StatusForbidden
+	case "redirect":
+		if it.Status != 0 {
+			return it.Status
+		}
+		return http.StatusFound
 	}
 	return defaultStatusCode
 }
@@ -284,19 +967,86 @@ func convertFasthttpToStdRequest(c *fiber.Ctx) (*http.Request, error) {
 	return req, nil
 }
 
+// cloneRequestForBackground deep-copies req so it remains safe to use from a
+// goroutine that outlives the originating Fiber handler, such as
+// dispatchOutOfBand. adaptor.ConvertRequest (via fasthttpadaptor) builds its
+// *http.Request with the method, proto, host, header and body all aliasing
+// the fasthttp RequestCtx's own buffers; per fasthttpadaptor's own doc, that
+// request "must not be used after the fasthttp handler has returned", since
+// fasthttp reuses those buffers for later connections. strings.Clone forces
+// a copy of the backing bytes for every ctx-derived string, and the body is
+// read into an independent byte slice.
+// cloneRequestForBackground 对 req 做深拷贝, 使其可以安全地在超出原
+// Fiber handler 生命周期的 goroutine(例如 dispatchOutOfBand)中使用。
+// adaptor.ConvertRequest(经由 fasthttpadaptor)构造的 *http.Request 中,
+// method、proto、host、header 与 body 均别名指向 fasthttp RequestCtx 自身
+// 的缓冲区; 按 fasthttpadaptor 自己的文档说明, 该请求"在 fasthttp handler
+// 返回后不得再使用", 因为 fasthttp 会为后续连接复用这些缓冲区。此处对每个
+// 源自 ctx 的字符串使用 strings.Clone 强制拷贝底层字节, 并将请求体读入
+// 独立的字节切片
+func cloneRequestForBackground(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	clone.Method = strings.Clone(req.Method)
+	clone.Proto = strings.Clone(req.Proto)
+	clone.Host = strings.Clone(req.Host)
+	clone.RemoteAddr = strings.Clone(req.RemoteAddr)
+	clone.RequestURI = strings.Clone(req.RequestURI)
+
+	clone.Header = make(http.Header, len(req.Header))
+	for k, vs := range req.Header {
+		cloned := make([]string, len(vs))
+		for i, v := range vs {
+			cloned[i] = strings.Clone(v)
+		}
+		clone.Header[strings.Clone(k)] = cloned
+	}
+
+	if req.URL != nil {
+		u := *req.URL
+		u.Scheme = strings.Clone(u.Scheme)
+		u.Opaque = strings.Clone(u.Opaque)
+		u.Host = strings.Clone(u.Host)
+		u.Path = strings.Clone(u.Path)
+		u.RawPath = strings.Clone(u.RawPath)
+		u.RawQuery = strings.Clone(u.RawQuery)
+		u.Fragment = strings.Clone(u.Fragment)
+		clone.URL = &u
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = io.NopCloser(bytes.NewReader(body))
+		clone.ContentLength = int64(len(body))
+	}
+
+	return clone, nil
+}
+
 // createWAFWithCfg creates a Coraza WAF instance | 根据配置创建 Coraza WAF 实例
 func createWAFWithCfg(cfg CorazaCfg) (coraza.WAF, error) {
 
 	for idx := range cfg.DirectivesFile {
 		if _, err := os.Stat(cfg.DirectivesFile[idx]); err != nil {
-			panic("WAF directives file not found")
+			return nil, fmt.Errorf("WAF directives file not found: %s: %w", cfg.DirectivesFile[idx], err)
 		}
 	}
 
 	wafConfig := coraza.NewWAFConfig()
 
-	if cfg.EnableErrorLog {
-		wafConfig = wafConfig.WithErrorCallback(logError)
+	if cfg.EnableErrorLog || cfg.OnMatch != nil {
+		enableErrorLog := cfg.EnableErrorLog
+		onMatch := cfg.OnMatch
+		wafConfig = wafConfig.WithErrorCallback(func(mr types.MatchedRule) {
+			if enableErrorLog {
+				logError(mr)
+			}
+			if onMatch != nil {
+				onMatch(mr)
+			}
+		})
 	}
 	if cfg.RequestBodyAccess {
 		wafConfig = wafConfig.WithRequestBodyAccess()
@@ -326,5 +1076,64 @@ func createWAFWithCfg(cfg CorazaCfg) (coraza.WAF, error) {
 	for idx := range cfg.DirectivesFile {
 		wafConfig = wafConfig.WithDirectivesFromFile(cfg.DirectivesFile[idx])
 	}
+
+	for _, d := range cfg.Directives {
+		wafConfig = wafConfig.WithDirectives(d)
+	}
+
+	if cfg.EmbeddedFS != nil {
+		for _, p := range cfg.EmbeddedPaths {
+			data, err := fs.ReadFile(cfg.EmbeddedFS, p)
+			if err != nil {
+				return nil, fmt.Errorf("reading embedded directive %s: %w", p, err)
+			}
+			wafConfig = wafConfig.WithDirectives(string(data))
+		}
+	}
+
+	for _, rd := range cfg.RemoteDirectives {
+		directives, err := fetchRemoteDirective(rd)
+		if err != nil {
+			return nil, fmt.Errorf("fetching remote directive %s: %w", rd.URL, err)
+		}
+		wafConfig = wafConfig.WithDirectives(directives)
+	}
+
 	return coraza.NewWAF(wafConfig)
 }
+
+// fetchRemoteDirective downloads rd.URL and verifies it against rd.SHA256
+// when set, returning the directive body to be passed through WithDirectives.
+// fetchRemoteDirective 下载 rd.URL 对应的内容, 并在设置了 rd.SHA256 时校验
+// 其校验和, 返回的规则内容会通过 WithDirectives 加载
+func fetchRemoteDirective(rd RemoteDirective) (string, error) {
+	timeout := rd.Timeout
+	if timeout <= 0 {
+		timeout = defaultRemoteDirectiveTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Get(rd.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if rd.SHA256 != "" {
+		sum := sha256.Sum256(body)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), rd.SHA256) {
+			return "", fmt.Errorf("checksum mismatch")
+		}
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2026 GoFurry
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package corazalite
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics holds the Prometheus collectors backing an Engine. It is created
+// once by newMetrics and its methods are nil-safe, so call sites don't need
+// to special-case an Engine built without a MetricsRegisterer.
+// metrics 持有 Engine 背后的 Prometheus 采集器。由 newMetrics 创建一次,
+// 其方法均对 nil 安全, 调用方无需为未配置 MetricsRegisterer 的 Engine
+// 单独处理
+type metrics struct {
+	requestsTotal     prometheus.Counter
+	blockedTotal      *prometheus.CounterVec
+	processingSeconds prometheus.Histogram
+	bodyBytes         prometheus.Histogram
+}
+
+// newMetrics registers the WAF collectors on reg and returns them, or
+// returns nil if reg is nil, disabling observation entirely. Registration
+// failures (e.g. multiple Engines sharing one Registerer) are returned as
+// an error rather than left to panic, so New keeps its guarantee of never
+// panicking.
+// newMetrics 在 reg 上注册 WAF 采集器并返回; 若 reg 为 nil 则返回 nil,
+// 完全关闭指标采集。注册失败(例如多个 Engine 共用同一个 Registerer)以
+// error 形式返回而非 panic, 以保持 New "绝不 panic" 的承诺
+func newMetrics(reg prometheus.Registerer) (*metrics, error) {
+	if reg == nil {
+		return nil, nil
+	}
+
+	m := &metrics{
+		requestsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "waf_requests_total",
+			Help: "Total number of requests seen by the WAF.",
+		}),
+		blockedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "waf_blocked_total",
+			Help: "Total number of matched rules, by rule ID and severity.",
+		}, []string{"rule_id", "severity"}),
+		processingSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "waf_processing_seconds",
+			Help:    "Time spent processing a request/response pair through the WAF.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		bodyBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "waf_body_bytes",
+			Help:    "Size in bytes of request and response bodies inspected by the WAF.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{m.requestsTotal, m.blockedTotal, m.processingSeconds, m.bodyBytes} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("registering WAF metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// observeRequest records one incoming request.
+// observeRequest 记录一次到达的请求
+func (m *metrics) observeRequest() {
+	if m == nil {
+		return
+	}
+	m.requestsTotal.Inc()
+}
+
+// observeMatch records one matched rule, keyed by rule ID and severity. It
+// is called from the OnMatch wrapper installed by Reload, so it fires for
+// every matched rule regardless of whether the transaction was ultimately
+// interrupted.
+// observeMatch 记录一次命中规则, 以规则 ID 与严重级别为标签。由 Reload 安装的
+// OnMatch 包装函数调用, 因此无论事务最终是否被拦截, 每条命中规则都会被记录
+func (m *metrics) observeMatch(mr types.MatchedRule) {
+	if m == nil {
+		return
+	}
+	m.blockedTotal.WithLabelValues(strconv.Itoa(mr.Rule().ID()), mr.Rule().Severity().String()).Inc()
+}
+
+// observeProcessing records the wall-clock time spent processing a request.
+// observeProcessing 记录处理一次请求所花费的时间
+func (m *metrics) observeProcessing(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.processingSeconds.Observe(d.Seconds())
+}
+
+// observeBodyBytes records the size of a request or response body inspected
+// by the WAF.
+// observeBodyBytes 记录一次被 WAF 检查的请求或响应体大小
+func (m *metrics) observeBodyBytes(n int) {
+	if m == nil {
+		return
+	}
+	m.bodyBytes.Observe(float64(n))
+}